@@ -4,66 +4,273 @@
 package main
 
 import (
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 func main() {
-	http.HandleFunc("/", handleRoot)
-	http.HandleFunc("/env", handleEnv)
-	http.HandleFunc("/health", handleHealth)
+	// Routes are registered on a dedicated mux rather than
+	// http.DefaultServeMux: net/http/pprof and expvar self-register their
+	// handlers onto the default mux from their own init() the moment
+	// they're imported, regardless of debugEnabled(). Never serving the
+	// default mux keeps those handlers unreachable unless newDebugMux()
+	// explicitly wires equivalents in below.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", metricsMiddleware("/", loggingMiddleware("/", handleRoot)))
+	mux.HandleFunc("/env", metricsMiddleware("/env", loggingMiddleware("/env", handleEnv)))
+	mux.HandleFunc("/health", metricsMiddleware("/health", loggingMiddleware("/health", handleHealth)))
+	mux.HandleFunc("/whoami", metricsMiddleware("/whoami", loggingMiddleware("/whoami", handleWhoami)))
+	registerMetricsHandler(mux)
+
+	if debugEnabled() {
+		mux.Handle("/debug/", newDebugMux())
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	fmt.Printf("cf-env starting on port %s\n", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
+	server := newHTTPServer(":"+port, mux)
+	slog.Info("cf-env starting", "port", port)
+	if err := runWithGracefulShutdown(server); err != nil {
+		slog.Error("server error", "error", err)
 		os.Exit(1)
 	}
+	slog.Info("cf-env stopped")
 }
 
-func handleRoot(w http.ResponseWriter, r *http.Request) {
+// debugEnabled reports whether CF_ENV_ENABLE_DEBUG opts this instance into
+// the /debug subtree. Off by default since pprof and expvar leak internals
+// that shouldn't be exposed on a production route.
+func debugEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("CF_ENV_ENABLE_DEBUG"))
+	return enabled
+}
+
+// newDebugMux builds a separate mux for pprof and expvar so they are only
+// ever reachable when debugEnabled() lets main mount it under /debug/ on the
+// app's own mux.
+func newDebugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/", handleDebugIndex)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
+
+func handleDebugIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "=== Debug Endpoints ===")
+	fmt.Fprintln(w, "/debug/vars              expvar counters")
+	fmt.Fprintln(w, "/debug/pprof/            pprof index")
+	fmt.Fprintln(w, "/debug/pprof/goroutine   goroutine dump")
+	fmt.Fprintln(w, "/debug/pprof/profile     30s CPU profile")
+	fmt.Fprintln(w, "/debug/pprof/trace       execution trace")
+}
 
-	fmt.Fprintln(w, "=== CF Instance Info ===")
-	fmt.Fprintf(w, "CF_INSTANCE_IP:    %s\n", os.Getenv("CF_INSTANCE_IP"))
-	fmt.Fprintf(w, "CF_INSTANCE_INDEX: %s\n", os.Getenv("CF_INSTANCE_INDEX"))
-	fmt.Fprintf(w, "CF_INSTANCE_GUID:  %s\n", os.Getenv("CF_INSTANCE_GUID"))
-	fmt.Fprintf(w, "CF_INSTANCE_ADDR:  %s\n", os.Getenv("CF_INSTANCE_ADDR"))
-	fmt.Fprintf(w, "INSTANCE_GUID:     %s\n", os.Getenv("INSTANCE_GUID"))
-	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "=== Application Info ===")
-	fmt.Fprintf(w, "VCAP_APPLICATION present: %v\n", os.Getenv("VCAP_APPLICATION") != "")
-	fmt.Fprintf(w, "MEMORY_LIMIT:      %s\n", os.Getenv("MEMORY_LIMIT"))
-	fmt.Fprintf(w, "PORT:              %s\n", os.Getenv("PORT"))
+// vcapApplication is the subset of VCAP_APPLICATION fields this demo cares
+// about: isolation segment placement and the identifiers needed to tell
+// instances apart.
+type vcapApplication struct {
+	ApplicationID    string   `json:"application_id"`
+	ApplicationName  string   `json:"application_name"`
+	SpaceID          string   `json:"space_id"`
+	SpaceName        string   `json:"space_name"`
+	OrganizationName string   `json:"organization_name"`
+	ApplicationURIs  []string `json:"application_uris"`
+	IsolationSegment string   `json:"isolation_segment"`
+	CFAPI            string   `json:"cf_api"`
 }
 
-func handleEnv(w http.ResponseWriter, r *http.Request) {
+// vcapServiceSummary is a redacted view of a single bound service: enough to
+// see what's bound without leaking credentials.
+type vcapServiceSummary struct {
+	Name  string   `json:"name"`
+	Label string   `json:"label"`
+	Plan  string   `json:"plan"`
+	Tags  []string `json:"tags"`
+}
+
+// rootPayload is the JSON shape returned by handleRoot. The text/plain
+// rendering below shows the instance fields plus INSTANCE_GUID, and only
+// a subset of the application fields.
+type rootPayload struct {
+	Instance struct {
+		IP    string `json:"cf_instance_ip"`
+		Index string `json:"cf_instance_index"`
+		GUID  string `json:"cf_instance_guid"`
+		Addr  string `json:"cf_instance_addr"`
+	} `json:"instance"`
+	Application *vcapApplication `json:"application,omitempty"`
+	MemoryLimit string           `json:"memory_limit"`
+	Port        string           `json:"port"`
+}
+
+// envPayload is the JSON shape returned by handleEnv: all environment
+// variables, with VCAP_SERVICES replaced by a redacted summary.
+type envPayload struct {
+	Environment []string             `json:"environment"`
+	Services    []vcapServiceSummary `json:"services,omitempty"`
+}
+
+// healthPayload is the JSON shape returned by handleHealth.
+type healthPayload struct {
+	Status string `json:"status"`
+}
+
+// wantsJSON reports whether the request asked for a JSON response, either
+// via the Accept header or the ?format=json query parameter.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// render writes payload to w as either JSON or, via textFn, plain text,
+// depending on what the request asked for. This keeps the two output modes
+// from drifting apart as fields get added.
+func render(w http.ResponseWriter, r *http.Request, payload interface{}, textFn func(w http.ResponseWriter)) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(payload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
+	textFn(w)
+}
+
+// parseVCAPApplication parses VCAP_APPLICATION, returning nil if it is
+// unset or malformed.
+func parseVCAPApplication() *vcapApplication {
+	raw := os.Getenv("VCAP_APPLICATION")
+	if raw == "" {
+		return nil
+	}
 
-	// Get all environment variables and sort them
+	var app vcapApplication
+	if err := json.Unmarshal([]byte(raw), &app); err != nil {
+		return nil
+	}
+	return &app
+}
+
+// parseVCAPServices parses VCAP_SERVICES into a redacted summary per bound
+// service, dropping credentials and anything else not listed here.
+func parseVCAPServices() []vcapServiceSummary {
+	raw := os.Getenv("VCAP_SERVICES")
+	if raw == "" {
+		return nil
+	}
+
+	var byLabel map[string][]struct {
+		Name  string   `json:"name"`
+		Label string   `json:"label"`
+		Plan  string   `json:"plan"`
+		Tags  []string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(raw), &byLabel); err != nil {
+		return nil
+	}
+
+	var summaries []vcapServiceSummary
+	for _, instances := range byLabel {
+		for _, inst := range instances {
+			summaries = append(summaries, vcapServiceSummary{
+				Name:  inst.Name,
+				Label: inst.Label,
+				Plan:  inst.Plan,
+				Tags:  inst.Tags,
+			})
+		}
+	}
+	return summaries
+}
+
+func handleRoot(w http.ResponseWriter, r *http.Request) {
+	var payload rootPayload
+	payload.Instance.IP = os.Getenv("CF_INSTANCE_IP")
+	payload.Instance.Index = os.Getenv("CF_INSTANCE_INDEX")
+	payload.Instance.GUID = os.Getenv("CF_INSTANCE_GUID")
+	payload.Instance.Addr = os.Getenv("CF_INSTANCE_ADDR")
+	payload.Application = parseVCAPApplication()
+	payload.MemoryLimit = os.Getenv("MEMORY_LIMIT")
+	payload.Port = os.Getenv("PORT")
+
+	render(w, r, payload, func(w http.ResponseWriter) {
+		fmt.Fprintln(w, "=== CF Instance Info ===")
+		fmt.Fprintf(w, "CF_INSTANCE_IP:    %s\n", payload.Instance.IP)
+		fmt.Fprintf(w, "CF_INSTANCE_INDEX: %s\n", payload.Instance.Index)
+		fmt.Fprintf(w, "CF_INSTANCE_GUID:  %s\n", payload.Instance.GUID)
+		fmt.Fprintf(w, "CF_INSTANCE_ADDR:  %s\n", payload.Instance.Addr)
+		fmt.Fprintf(w, "INSTANCE_GUID:     %s\n", os.Getenv("INSTANCE_GUID"))
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "=== Application Info ===")
+		if app := payload.Application; app != nil {
+			fmt.Fprintf(w, "application_id:     %s\n", app.ApplicationID)
+			fmt.Fprintf(w, "space_name:         %s\n", app.SpaceName)
+			fmt.Fprintf(w, "org_name:           %s\n", app.OrganizationName)
+			fmt.Fprintf(w, "application_uris:   %s\n", strings.Join(app.ApplicationURIs, ", "))
+			fmt.Fprintf(w, "isolation_segment:  %s\n", app.IsolationSegment)
+		} else {
+			fmt.Fprintln(w, "VCAP_APPLICATION present: false")
+		}
+		fmt.Fprintf(w, "MEMORY_LIMIT:      %s\n", payload.MemoryLimit)
+		fmt.Fprintf(w, "PORT:              %s\n", payload.Port)
+	})
+}
+
+func handleEnv(w http.ResponseWriter, r *http.Request) {
 	envVars := os.Environ()
 	sort.Strings(envVars)
 
-	fmt.Fprintln(w, "=== All Environment Variables ===")
+	payload := envPayload{
+		Services: parseVCAPServices(),
+	}
 	for _, env := range envVars {
-		// Skip sensitive variables
 		if strings.HasPrefix(env, "VCAP_SERVICES") {
-			fmt.Fprintln(w, "VCAP_SERVICES=[REDACTED]")
+			payload.Environment = append(payload.Environment, "VCAP_SERVICES=[REDACTED]")
 			continue
 		}
-		fmt.Fprintln(w, env)
+		payload.Environment = append(payload.Environment, env)
 	}
+
+	render(w, r, payload, func(w http.ResponseWriter) {
+		fmt.Fprintln(w, "=== All Environment Variables ===")
+		for _, env := range payload.Environment {
+			fmt.Fprintln(w, env)
+		}
+		if len(payload.Services) > 0 {
+			fmt.Fprintln(w, "")
+			fmt.Fprintln(w, "=== Bound Services ===")
+			for _, svc := range payload.Services {
+				fmt.Fprintf(w, "%s: label=%s plan=%s tags=%s\n", svc.Name, svc.Label, svc.Plan, strings.Join(svc.Tags, ","))
+			}
+		}
+	})
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
+	// /health has always returned JSON regardless of Accept, so it has no
+	// text/plain rendering to keep in sync.
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, `{"status":"healthy"}`)
+	json.NewEncoder(w).Encode(healthPayload{Status: "healthy"})
 }
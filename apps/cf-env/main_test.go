@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseVCAPApplication(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("VCAP_APPLICATION", "")
+		if got := parseVCAPApplication(); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Setenv("VCAP_APPLICATION", "{not json")
+		if got := parseVCAPApplication(); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("VCAP_APPLICATION", `{
+			"application_id": "app-1",
+			"space_name": "space-1",
+			"organization_name": "org-1",
+			"application_uris": ["app-1.example.com"],
+			"isolation_segment": "seg-1",
+			"cf_api": "https://api.example.com"
+		}`)
+
+		got := parseVCAPApplication()
+		if got == nil {
+			t.Fatal("got nil, want parsed application")
+		}
+		want := &vcapApplication{
+			ApplicationID:    "app-1",
+			SpaceName:        "space-1",
+			OrganizationName: "org-1",
+			ApplicationURIs:  []string{"app-1.example.com"},
+			IsolationSegment: "seg-1",
+			CFAPI:            "https://api.example.com",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestParseVCAPServices(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("VCAP_SERVICES", "")
+		if got := parseVCAPServices(); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Setenv("VCAP_SERVICES", "not json")
+		if got := parseVCAPServices(); got != nil {
+			t.Fatalf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("redacts credentials", func(t *testing.T) {
+		t.Setenv("VCAP_SERVICES", `{
+			"p-mysql": [{
+				"name": "my-db",
+				"label": "p-mysql",
+				"plan": "small",
+				"tags": ["mysql", "relational"],
+				"credentials": {"username": "u", "password": "p"}
+			}]
+		}`)
+
+		got := parseVCAPServices()
+		want := []vcapServiceSummary{{
+			Name:  "my-db",
+			Label: "p-mysql",
+			Plan:  "small",
+			Tags:  []string{"mysql", "relational"},
+		}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestWantsJSON(t *testing.T) {
+	cases := []struct {
+		name   string
+		target string
+		accept string
+		want   bool
+	}{
+		{"no hints", "/env", "", false},
+		{"accept header", "/env", "application/json", true},
+		{"accept header with charset", "/env", "application/json; charset=utf-8", true},
+		{"query param", "/env?format=json", "", true},
+		{"text accept header", "/env", "text/plain", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			if got := wantsJSON(r); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
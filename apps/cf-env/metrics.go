@@ -0,0 +1,91 @@
+// ABOUTME: Prometheus instrumentation for cf-env: request metrics plus a
+// ABOUTME: per-instance info gauge labeled with isolation segment placement
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cf_env_requests_total",
+		Help: "Total HTTP requests handled by cf-env, by method, path, and status.",
+	}, []string{"method", "path", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cf_env_request_duration_seconds",
+		Help:    "HTTP request latency for cf-env, by method and path.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	instanceInfo = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "cf_env_instance_info",
+		Help:        "Always 1; labels identify this instance's isolation segment placement.",
+		ConstLabels: instanceInfoLabels(),
+	})
+)
+
+// instanceInfoLabels derives the constant labels for cf_env_instance_info
+// from CF_INSTANCE_* env vars and VCAP_APPLICATION, so the gauge can be
+// used to group and graph traffic by isolation segment in Grafana.
+func instanceInfoLabels() prometheus.Labels {
+	labels := prometheus.Labels{
+		"cf_instance_index": os.Getenv("CF_INSTANCE_INDEX"),
+		"cf_instance_guid":  os.Getenv("CF_INSTANCE_GUID"),
+		"application_id":    "",
+		"space_name":        "",
+		"org_name":          "",
+		"isolation_segment": "",
+	}
+
+	if app := parseVCAPApplication(); app != nil {
+		labels["application_id"] = app.ApplicationID
+		labels["space_name"] = app.SpaceName
+		labels["org_name"] = app.OrganizationName
+		labels["isolation_segment"] = app.IsolationSegment
+	}
+
+	return labels
+}
+
+func init() {
+	instanceInfo.Set(1)
+}
+
+// metricsMiddleware wraps h to record cf_env_requests_total and
+// cf_env_request_duration_seconds for every request it serves.
+func metricsMiddleware(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r)
+
+		requestDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(rec.status)).Inc()
+	}
+}
+
+// statusRecorder captures the status code written to an http.ResponseWriter
+// so middleware can observe it after the handler has run.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func registerMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,251 @@
+// ABOUTME: Resolves and caches this instance's isolation segment placement
+// ABOUTME: by calling the Cloud Controller v3 API for /whoami
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// whoamiResult is the JSON shape returned by handleWhoami.
+type whoamiResult struct {
+	ApplicationID    string `json:"application_id"`
+	SpaceGUID        string `json:"space_guid"`
+	OrganizationGUID string `json:"organization_guid"`
+	IsolationSegment string `json:"isolation_segment"`
+	DiegoCellIP      string `json:"diego_cell_ip"`
+}
+
+// whoamiCache memoizes the last successful lookup for ttl, since the
+// isolation segment assignment for a space rarely changes and every app
+// instance hitting /v3 on each request would be wasteful.
+type whoamiCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	fetched time.Time
+	result  *whoamiResult
+}
+
+var defaultWhoamiCache = &whoamiCache{ttl: whoamiCacheTTL()}
+
+// whoamiCacheTTL reads CF_ENV_WHOAMI_CACHE_TTL (seconds), defaulting to 30s.
+func whoamiCacheTTL() time.Duration {
+	raw := os.Getenv("CF_ENV_WHOAMI_CACHE_TTL")
+	if raw == "" {
+		return 30 * time.Second
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (c *whoamiCache) get(fetch func() (*whoamiResult, error)) (*whoamiResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.result != nil && time.Since(c.fetched) < c.ttl {
+		return c.result, nil
+	}
+
+	result, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	c.result = result
+	c.fetched = time.Now()
+	return result, nil
+}
+
+// cfAPIClient calls the Cloud Controller v3 API using whichever credentials
+// this instance has available.
+type cfAPIClient struct {
+	httpClient *http.Client
+	apiBase    string
+	token      string
+}
+
+// newCFAPIClient builds a client for apiBase, authenticating with a bearer
+// token if one is configured (CF_API_TOKEN or a bound user-provided
+// service), falling back to the instance identity certificate for mTLS.
+func newCFAPIClient(apiBase string) (*cfAPIClient, error) {
+	if token := cfAPIToken(); token != "" {
+		httpClient := &http.Client{Timeout: 10 * time.Second}
+		return &cfAPIClient{httpClient: httpClient, apiBase: apiBase, token: token}, nil
+	}
+
+	httpClient, err := instanceIdentityHTTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("no CF API token and instance identity cert unavailable: %w", err)
+	}
+	return &cfAPIClient{httpClient: httpClient, apiBase: apiBase}, nil
+}
+
+// cfAPIToken looks for a bearer token in CF_API_TOKEN, then in any bound
+// user-provided service carrying a "token" credential.
+func cfAPIToken() string {
+	if token := os.Getenv("CF_API_TOKEN"); token != "" {
+		return token
+	}
+
+	raw := os.Getenv("VCAP_SERVICES")
+	if raw == "" {
+		return ""
+	}
+
+	var byLabel map[string][]struct {
+		Credentials struct {
+			Token string `json:"token"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal([]byte(raw), &byLabel); err != nil {
+		return ""
+	}
+
+	for _, inst := range byLabel["user-provided"] {
+		if inst.Credentials.Token != "" {
+			return inst.Credentials.Token
+		}
+	}
+	return ""
+}
+
+// instanceIdentityHTTPClient builds an http.Client presenting this
+// instance's CF_INSTANCE_CERT/CF_INSTANCE_KEY pair for mTLS, as issued by
+// Diego's built-in instance identity credhub.
+func instanceIdentityHTTPClient() (*http.Client, error) {
+	certPath := os.Getenv("CF_INSTANCE_CERT")
+	keyPath := os.Getenv("CF_INSTANCE_KEY")
+	if certPath == "" || keyPath == "" {
+		return nil, fmt.Errorf("CF_INSTANCE_CERT/CF_INSTANCE_KEY not set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading instance identity cert: %w", err)
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, nil
+}
+
+func (c *cfAPIClient) get(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.apiBase+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// lookupWhoami calls the Cloud Controller v3 API to resolve this app's
+// space, organization, and isolation segment placement.
+func lookupWhoami(app *vcapApplication) (*whoamiResult, error) {
+	if app == nil || app.CFAPI == "" {
+		return nil, fmt.Errorf("VCAP_APPLICATION missing or has no cf_api")
+	}
+
+	client, err := newCFAPIClient(app.CFAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	var appResp struct {
+		Relationships struct {
+			Space struct {
+				Data struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			} `json:"space"`
+		} `json:"relationships"`
+	}
+	if err := client.get("/v3/apps/"+app.ApplicationID, &appResp); err != nil {
+		return nil, fmt.Errorf("fetching app: %w", err)
+	}
+	spaceGUID := appResp.Relationships.Space.Data.GUID
+
+	var spaceResp struct {
+		Relationships struct {
+			Organization struct {
+				Data struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			} `json:"organization"`
+			IsolationSegment struct {
+				Data struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			} `json:"isolation_segment"`
+		} `json:"relationships"`
+	}
+	if err := client.get("/v3/spaces/"+spaceGUID, &spaceResp); err != nil {
+		return nil, fmt.Errorf("fetching space: %w", err)
+	}
+
+	var segmentName string
+	if guid := spaceResp.Relationships.IsolationSegment.Data.GUID; guid != "" {
+		var segResp struct {
+			Name string `json:"name"`
+		}
+		if err := client.get("/v3/isolation_segments/"+guid, &segResp); err != nil {
+			return nil, fmt.Errorf("fetching isolation segment: %w", err)
+		}
+		segmentName = segResp.Name
+	}
+
+	return &whoamiResult{
+		ApplicationID:    app.ApplicationID,
+		SpaceGUID:        spaceGUID,
+		OrganizationGUID: spaceResp.Relationships.Organization.Data.GUID,
+		IsolationSegment: segmentName,
+		DiegoCellIP:      os.Getenv("CF_INSTANCE_IP"),
+	}, nil
+}
+
+func handleWhoami(w http.ResponseWriter, r *http.Request) {
+	app := parseVCAPApplication()
+
+	result, err := defaultWhoamiCache.get(func() (*whoamiResult, error) {
+		return lookupWhoami(app)
+	})
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, "whoami lookup failed: %s\n", err)
+		return
+	}
+
+	render(w, r, result, func(w http.ResponseWriter) {
+		fmt.Fprintln(w, "=== Isolation Segment Placement ===")
+		fmt.Fprintf(w, "application_id:     %s\n", result.ApplicationID)
+		fmt.Fprintf(w, "space_guid:         %s\n", result.SpaceGUID)
+		fmt.Fprintf(w, "organization_guid:  %s\n", result.OrganizationGUID)
+		fmt.Fprintf(w, "isolation_segment:  %s\n", result.IsolationSegment)
+		fmt.Fprintf(w, "diego_cell_ip:      %s\n", result.DiegoCellIP)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeCCAPI serves the minimal subset of the Cloud Controller v3 API that
+// lookupWhoami walks: app -> space -> isolation segment.
+func fakeCCAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/apps/app-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"relationships": map[string]any{
+				"space": map[string]any{"data": map[string]any{"guid": "space-1"}},
+			},
+		})
+	})
+	mux.HandleFunc("/v3/spaces/space-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"relationships": map[string]any{
+				"organization":      map[string]any{"data": map[string]any{"guid": "org-1"}},
+				"isolation_segment": map[string]any{"data": map[string]any{"guid": "seg-1"}},
+			},
+		})
+	})
+	mux.HandleFunc("/v3/isolation_segments/seg-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"name": "isoseg-a"})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLookupWhoami(t *testing.T) {
+	server := fakeCCAPI(t)
+	t.Setenv("CF_API_TOKEN", "test-token")
+	t.Setenv("CF_INSTANCE_IP", "10.0.0.5")
+
+	app := &vcapApplication{ApplicationID: "app-1", CFAPI: server.URL}
+
+	got, err := lookupWhoami(app)
+	if err != nil {
+		t.Fatalf("lookupWhoami returned error: %v", err)
+	}
+
+	want := &whoamiResult{
+		ApplicationID:    "app-1",
+		SpaceGUID:        "space-1",
+		OrganizationGUID: "org-1",
+		IsolationSegment: "isoseg-a",
+		DiegoCellIP:      "10.0.0.5",
+	}
+	if *got != *want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLookupWhoamiSpaceWithoutIsolationSegment(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/apps/app-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"relationships": map[string]any{
+				"space": map[string]any{"data": map[string]any{"guid": "space-1"}},
+			},
+		})
+	})
+	mux.HandleFunc("/v3/spaces/space-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"relationships": map[string]any{
+				"organization": map[string]any{"data": map[string]any{"guid": "org-1"}},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Setenv("CF_API_TOKEN", "test-token")
+
+	got, err := lookupWhoami(&vcapApplication{ApplicationID: "app-1", CFAPI: server.URL})
+	if err != nil {
+		t.Fatalf("lookupWhoami returned error: %v", err)
+	}
+	if got.IsolationSegment != "" {
+		t.Fatalf("got isolation segment %q, want empty when space has none assigned", got.IsolationSegment)
+	}
+}
+
+func TestLookupWhoamiMissingCFAPI(t *testing.T) {
+	if _, err := lookupWhoami(&vcapApplication{ApplicationID: "app-1"}); err == nil {
+		t.Fatal("expected error when VCAP_APPLICATION has no cf_api")
+	}
+	if _, err := lookupWhoami(nil); err == nil {
+		t.Fatal("expected error for nil VCAP_APPLICATION")
+	}
+}
+
+func TestCFAPITokenFallback(t *testing.T) {
+	t.Run("env var", func(t *testing.T) {
+		t.Setenv("CF_API_TOKEN", "from-env")
+		t.Setenv("VCAP_SERVICES", "")
+		if got := cfAPIToken(); got != "from-env" {
+			t.Fatalf("got %q, want %q", got, "from-env")
+		}
+	})
+
+	t.Run("user-provided service", func(t *testing.T) {
+		t.Setenv("CF_API_TOKEN", "")
+		t.Setenv("VCAP_SERVICES", `{
+			"user-provided": [{
+				"name": "cf-api-token",
+				"credentials": {"token": "from-service"}
+			}]
+		}`)
+		if got := cfAPIToken(); got != "from-service" {
+			t.Fatalf("got %q, want %q", got, "from-service")
+		}
+	})
+
+	t.Run("none configured", func(t *testing.T) {
+		t.Setenv("CF_API_TOKEN", "")
+		t.Setenv("VCAP_SERVICES", "")
+		if got := cfAPIToken(); got != "" {
+			t.Fatalf("got %q, want empty", got)
+		}
+	})
+}
+
+func TestWhoamiCacheGet(t *testing.T) {
+	cache := &whoamiCache{ttl: time.Minute}
+
+	calls := 0
+	fetch := func() (*whoamiResult, error) {
+		calls++
+		return &whoamiResult{ApplicationID: "app-1"}, nil
+	}
+
+	if _, err := cache.get(fetch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.get(fetch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("fetch called %d times, want 1 (second get should hit cache)", calls)
+	}
+}
+
+func TestWhoamiCacheGetDoesNotCacheErrors(t *testing.T) {
+	cache := &whoamiCache{ttl: time.Minute}
+
+	calls := 0
+	fetch := func() (*whoamiResult, error) {
+		calls++
+		return nil, errors.New("boom")
+	}
+
+	if _, err := cache.get(fetch); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := cache.get(fetch); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 2 {
+		t.Fatalf("fetch called %d times, want 2 (errors must not be cached)", calls)
+	}
+}